@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// splitMemcacheServers parses the comma-separated value of
+// -memcache-servers into a slice of addresses.
+func splitMemcacheServers(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var servers []string
+	for _, addr := range strings.Split(s, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			servers = append(servers, addr)
+		}
+	}
+	return servers
+}
+
+// errCacheEmpty is returned by cacheBackend.Random when there are no
+// usable (non-expired) entries to serve.
+var errCacheEmpty = errors.New("cache is empty")
+
+// cacheBackend persists fetched extracts so they can be replayed later,
+// e.g. for reproducible fixtures or offline/CI runs. The default backend
+// is filesystem-based; a memcached-backed implementation is available
+// behind the 'memcache' build tag for sharing a cache across processes.
+type cacheBackend interface {
+	// Put stores value under key, overwriting any earlier value.
+	Put(ctx context.Context, key string, value []byte) error
+	// Random returns the bytes of a random non-expired entry, or
+	// errCacheEmpty if none are available.
+	Random(ctx context.Context) ([]byte, error)
+}
+
+// cacheKey returns a stable, content-addressed key for b. It is the
+// fallback used for TextSource backends, such as fileCorpusSource, that
+// have no natural identity of their own; backends that do (see textKeyer)
+// are keyed by that identity instead.
+func cacheKey(b []byte) string {
+	sum := sha1.Sum(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// textKeyer is implemented by TextSource backends whose entries have a
+// natural, stable identity -- e.g. wikiClient and wikiExtractClient key by
+// the Wikimedia page ID. cachingSource prefers this over cacheKey's
+// content hash so that re-fetching a revised page replaces its existing
+// cache entry instead of accumulating a duplicate alongside it.
+type textKeyer interface {
+	// randomTextKeyed behaves like TextSource.RandomText, additionally
+	// returning a key identifying the returned chunk.
+	randomTextKeyed(ctx context.Context) (key string, b []byte, err error)
+}
+
+// cachingSource wraps a TextSource, persisting every successfully fetched
+// chunk to backend. Caching is best-effort: a failure to persist does not
+// fail the underlying request.
+type cachingSource struct {
+	TextSource
+	backend cacheBackend
+}
+
+func (s *cachingSource) RandomText(ctx context.Context) ([]byte, error) {
+	if k, ok := s.TextSource.(textKeyer); ok {
+		key, b, err := k.randomTextKeyed(ctx)
+		if err != nil {
+			return nil, err
+		}
+		_ = s.backend.Put(ctx, key, b)
+		return b, nil
+	}
+
+	b, err := s.TextSource.RandomText(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_ = s.backend.Put(ctx, cacheKey(b), b)
+	return b, nil
+}
+
+// offlineSource is a TextSource that serves random entries from backend
+// without making any network calls, for reproducible fixtures and CI.
+type offlineSource struct {
+	backend cacheBackend
+}
+
+func (s *offlineSource) RandomText(ctx context.Context) ([]byte, error) {
+	return s.backend.Random(ctx)
+}
+
+// fsCacheBackend is the default cacheBackend. Each entry is stored as a
+// file under dir, alongside a small JSON index used for TTL filtering and
+// random selection. Expired entries are skipped by Random but not deleted.
+type fsCacheBackend struct {
+	dir string
+	ttl time.Duration // zero means entries never expire
+
+	mu    sync.Mutex
+	index cacheIndex
+}
+
+type cacheIndex struct {
+	Entries []cacheEntry `json:"entries"`
+}
+
+type cacheEntry struct {
+	Key      string    `json:"key"`
+	File     string    `json:"file"`
+	StoredAt time.Time `json:"storedAt"`
+}
+
+// newFSCacheBackend returns a cacheBackend that stores entries under dir,
+// creating it if necessary. Entries older than ttl are ignored by Random;
+// a zero ttl means entries never expire.
+func newFSCacheBackend(dir string, ttl time.Duration) (*fsCacheBackend, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	b := &fsCacheBackend{dir: dir, ttl: ttl}
+	if err := b.loadIndex(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *fsCacheBackend) indexPath() string {
+	return filepath.Join(b.dir, "index.json")
+}
+
+func (b *fsCacheBackend) loadIndex() error {
+	data, err := os.ReadFile(b.indexPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &b.index)
+}
+
+func (b *fsCacheBackend) saveIndex() error {
+	data, err := json.Marshal(b.index)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(b.indexPath(), data, 0o644)
+}
+
+func (b *fsCacheBackend) Put(_ context.Context, key string, value []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Keys may contain characters that are not valid in a file name (e.g.
+	// the '/' of wikiClient's URL-based keys), so the file is named after a
+	// hash of the key.
+	sum := sha1.Sum([]byte(key))
+	file := hex.EncodeToString(sum[:]) + ".txt"
+	if err := os.WriteFile(filepath.Join(b.dir, file), value, 0o644); err != nil {
+		return err
+	}
+	for i, e := range b.index.Entries {
+		if e.Key == key {
+			b.index.Entries[i].File = file
+			b.index.Entries[i].StoredAt = time.Now()
+			return b.saveIndex()
+		}
+	}
+	b.index.Entries = append(b.index.Entries, cacheEntry{Key: key, File: file, StoredAt: time.Now()})
+	return b.saveIndex()
+}
+
+func (b *fsCacheBackend) Random(_ context.Context) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var live []cacheEntry
+	for _, e := range b.index.Entries {
+		if b.ttl <= 0 || time.Since(e.StoredAt) < b.ttl {
+			live = append(live, e)
+		}
+	}
+	if len(live) == 0 {
+		return nil, errCacheEmpty
+	}
+	e := live[rand.Intn(len(live))]
+	return os.ReadFile(filepath.Join(b.dir, e.File))
+}
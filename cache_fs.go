@@ -0,0 +1,18 @@
+//go:build !memcache
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// newCacheBackend returns the cacheBackend selected by the -cache-dir and
+// -memcache-servers flags. This build does not include the memcached
+// backend; rebuild with '-tags memcache' to enable -memcache-servers.
+func newCacheBackend(dir string, ttl time.Duration, memcacheServers []string) (cacheBackend, error) {
+	if len(memcacheServers) > 0 {
+		return nil, errors.New("'-memcache-servers' requires building wikiipsum with '-tags memcache'")
+	}
+	return newFSCacheBackend(dir, ttl)
+}
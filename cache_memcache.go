@@ -0,0 +1,147 @@
+//go:build memcache
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// newCacheBackend returns the cacheBackend selected by the -cache-dir and
+// -memcache-servers flags. When memcacheServers is non-empty it takes
+// precedence, giving a cache shared across processes; otherwise it falls
+// back to the filesystem backend.
+func newCacheBackend(dir string, ttl time.Duration, memcacheServers []string) (cacheBackend, error) {
+	if len(memcacheServers) > 0 {
+		return newMemcacheCacheBackend(memcacheServers, ttl), nil
+	}
+	return newFSCacheBackend(dir, ttl)
+}
+
+// memcacheIndexKey is the memcache key under which the list of entry keys
+// is stored, since memcache has no native way to list or pick a random key.
+const memcacheIndexKey = "wikiipsum:index"
+
+// memcacheCacheBackend is a cacheBackend backed by memcached, for sharing
+// a cache across multiple wikiipsum processes. Build with '-tags memcache'.
+type memcacheCacheBackend struct {
+	client *memcache.Client
+	ttl    time.Duration
+}
+
+func newMemcacheCacheBackend(servers []string, ttl time.Duration) *memcacheCacheBackend {
+	return &memcacheCacheBackend{client: memcache.New(servers...), ttl: ttl}
+}
+
+func (b *memcacheCacheBackend) Put(_ context.Context, key string, value []byte) error {
+	// Expiration is relative seconds from now (memcache treats 0 as "never
+	// expire"), which matches b.ttl's own zero-value semantics. memcache
+	// reads values over 30 days as an absolute Unix time, so a longer ttl
+	// is sent in that form.
+	exp := int32(b.ttl.Seconds())
+	if b.ttl > 30*24*time.Hour {
+		exp = int32(time.Now().Add(b.ttl).Unix())
+	}
+	item := &memcache.Item{Key: key, Value: value, Expiration: exp}
+	if err := b.client.Set(item); err != nil {
+		return err
+	}
+	return b.addToIndex(key)
+}
+
+func (b *memcacheCacheBackend) addToIndex(key string) error {
+	keys, err := b.readIndex()
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if k == key {
+			return nil
+		}
+	}
+	keys = append(keys, key)
+	data, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	return b.client.Set(&memcache.Item{Key: memcacheIndexKey, Value: data})
+}
+
+func (b *memcacheCacheBackend) readIndex() ([]string, error) {
+	item, err := b.client.Get(memcacheIndexKey)
+	if err == memcache.ErrCacheMiss {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	if err := json.Unmarshal(item.Value, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Random picks a uniformly random live entry. Index entries expire from
+// memcache independently of the index itself, so a single miss doesn't mean
+// the cache is empty: it tries every indexed key, in random order, before
+// giving up, and prunes the keys it found stale along the way.
+func (b *memcacheCacheBackend) Random(context.Context) ([]byte, error) {
+	keys, err := b.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, errCacheEmpty
+	}
+
+	var stale []string
+	for _, i := range rand.Perm(len(keys)) {
+		item, err := b.client.Get(keys[i])
+		if err == memcache.ErrCacheMiss {
+			stale = append(stale, keys[i])
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		b.pruneIndex(stale)
+		return item.Value, nil
+	}
+
+	b.pruneIndex(stale)
+	return nil, errCacheEmpty
+}
+
+// pruneIndex removes stale keys from the index. Errors are ignored: this is
+// best-effort housekeeping, and leaving a stale key in the index only costs
+// Random an extra, already-handled miss next time.
+func (b *memcacheCacheBackend) pruneIndex(stale []string) {
+	if len(stale) == 0 {
+		return
+	}
+	isStale := make(map[string]bool, len(stale))
+	for _, k := range stale {
+		isStale[k] = true
+	}
+
+	keys, err := b.readIndex()
+	if err != nil {
+		return
+	}
+	kept := keys[:0]
+	for _, k := range keys {
+		if !isStale[k] {
+			kept = append(kept, k)
+		}
+	}
+	data, err := json.Marshal(kept)
+	if err != nil {
+		return
+	}
+	b.client.Set(&memcache.Item{Key: memcacheIndexKey, Value: data})
+}
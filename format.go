@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+)
+
+// Formatter wraps raw text chunks produced by a TextSource into an output
+// format (plain text, JSON, HTML, Markdown, ...). Begin and End bracket the
+// stream so formats that need a wrapper (e.g. a JSON array) can emit it;
+// Write returns the number of output bytes written so the caller's length
+// accounting keeps working regardless of format overhead.
+type Formatter interface {
+	Begin(w io.Writer) error
+	Write(b []byte) (int, error)
+	End() error
+}
+
+// newFormatter builds the Formatter selected by -format. tag is the HTML
+// tag used to wrap each chunk by the "html" and "html-min" formats; wrap
+// selects whether the "json" format emits a single JSON array instead of
+// one object per line.
+func newFormatter(format, tag string, wrap bool, w io.Writer) (Formatter, error) {
+	switch format {
+	case "", "text":
+		return &textFormatter{w: w}, nil
+	case "json":
+		return &jsonFormatter{w: w, wrap: wrap}, nil
+	case "html":
+		return &htmlFormatter{w: w, tag: tag}, nil
+	case "html-min":
+		return &htmlFormatter{w: w, tag: tag, minify: true}, nil
+	case "markdown":
+		return &markdownFormatter{w: w}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// textFormatter writes one chunk per line, matching wikiipsum's original
+// output.
+type textFormatter struct {
+	w io.Writer
+}
+
+func (f *textFormatter) Begin(w io.Writer) error { f.w = w; return nil }
+
+func (f *textFormatter) Write(b []byte) (int, error) {
+	return fmt.Fprintln(f.w, string(b))
+}
+
+func (f *textFormatter) End() error { return nil }
+
+// jsonFormatter writes each chunk as a {"extract": "..."} object, either as
+// a stream of newline-delimited objects, or as a single JSON array when
+// wrap is true.
+type jsonFormatter struct {
+	w     io.Writer
+	wrap  bool
+	first bool
+}
+
+type jsonExtract struct {
+	Extract string `json:"extract"`
+}
+
+func (f *jsonFormatter) Begin(w io.Writer) error {
+	f.w = w
+	f.first = true
+	if f.wrap {
+		_, err := fmt.Fprint(f.w, "[")
+		return err
+	}
+	return nil
+}
+
+func (f *jsonFormatter) Write(b []byte) (int, error) {
+	n := 0
+	if f.wrap && !f.first {
+		m, err := fmt.Fprint(f.w, ",")
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	f.first = false
+
+	enc, err := json.Marshal(jsonExtract{Extract: string(b)})
+	if err != nil {
+		return n, err
+	}
+	m, err := f.w.Write(enc)
+	n += m
+	if err != nil {
+		return n, err
+	}
+	if !f.wrap {
+		m, err = fmt.Fprintln(f.w)
+		n += m
+	}
+	return n, err
+}
+
+func (f *jsonFormatter) End() error {
+	if f.wrap {
+		_, err := fmt.Fprintln(f.w, "]")
+		return err
+	}
+	return nil
+}
+
+// htmlFormatter wraps each chunk in an HTML tag (default "p"), escaping the
+// chunk text so source characters like '&' and '<' can't break the markup.
+// When minify is set, the output is passed through a minifier so it can be
+// used as compact fixture data.
+type htmlFormatter struct {
+	w      io.Writer
+	tag    string
+	minify bool
+}
+
+func (f *htmlFormatter) Begin(w io.Writer) error {
+	f.w = w
+	if f.tag == "" {
+		f.tag = "p"
+	}
+	return nil
+}
+
+func (f *htmlFormatter) Write(b []byte) (int, error) {
+	out := fmt.Sprintf("<%s>%s</%s>\n", f.tag, html.EscapeString(string(b)), f.tag)
+	if f.minify {
+		out = minifyHTML(out)
+	}
+	return fmt.Fprint(f.w, out)
+}
+
+func (f *htmlFormatter) End() error { return nil }
+
+// minifyHTML drops whitespace that falls entirely between tags (e.g. the
+// trailing newline after a closing tag), and collapses any other run of
+// whitespace -- including newlines embedded in the chunk's own text, which
+// the "explaintext" Wikipedia API returns between paragraphs -- to a single
+// space, so in-text line breaks don't silently glue adjacent words
+// together. It is a small, dependency-free stand-in for a full minifier
+// such as tdewolff/minify, sufficient for the single-tag-per-chunk output
+// this formatter produces.
+func minifyHTML(s string) string {
+	isSpace := func(c byte) bool {
+		return c == ' ' || c == '\n' || c == '\t' || c == '\r'
+	}
+
+	out := make([]byte, 0, len(s))
+	afterTag := true // a leading run is whitespace between "nothing" and the first tag
+	for i := 0; i < len(s); {
+		c := s[i]
+		if !isSpace(c) {
+			out = append(out, c)
+			afterTag = c == '>'
+			i++
+			continue
+		}
+
+		j := i
+		for j < len(s) && isSpace(s[j]) {
+			j++
+		}
+		betweenTags := afterTag && (j == len(s) || s[j] == '<')
+		if !betweenTags {
+			out = append(out, ' ')
+		}
+		i = j
+	}
+	return string(out)
+}
+
+// markdownFormatter wraps each chunk as a Markdown paragraph.
+type markdownFormatter struct {
+	w io.Writer
+}
+
+func (f *markdownFormatter) Begin(w io.Writer) error { f.w = w; return nil }
+
+func (f *markdownFormatter) Write(b []byte) (int, error) {
+	return fmt.Fprintf(f.w, "%s\n\n", b)
+}
+
+func (f *markdownFormatter) End() error { return nil }
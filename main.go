@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -15,8 +16,7 @@ import (
 	"strings"
 	"time"
 
-	"github.com/cenkalti/backoff"
-	"golang.org/x/time/rate"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var usage = `Lorem Ipsum generates text using content from Wikipedia and prints it to the standard output.
@@ -36,12 +36,30 @@ var Version string
 // See https://en.wikipedia.org/api/rest_v1/.
 func main() {
 	var (
-		userAgent string
-		lang      string
-		lengthStr string
-		rateLimit float64
-		verbose   bool
-		version   bool
+		userAgent          string
+		lang               string
+		lengthStr          string
+		rateLimit          float64
+		verbose            bool
+		version            bool
+		source             string
+		corpusPath         string
+		format             string
+		tag                string
+		wrap               bool
+		paragraphsPerBlock int
+		metricsAddr        string
+		minRate            float64
+		maxRate            float64
+		aimdStep           float64
+		cacheDir           string
+		cacheTTL           time.Duration
+		offline            bool
+		memcacheServers    string
+		serveAddr          string
+		prefetchBuffer     int
+		clientRate         float64
+		clientBurst        int
 	)
 
 	flag.StringVar(&userAgent, "user-agent", "", "User agent header for API calls to Wikipedia. It should provide information how to contact you, e.g. admin@example.com")
@@ -50,6 +68,24 @@ func main() {
 	flag.Float64Var(&rateLimit, "rate", 0, "Request rate limit in req/s")
 	flag.BoolVar(&verbose, "verbose", false, "Verbose")
 	flag.BoolVar(&version, "version", false, "Print version")
+	flag.StringVar(&source, "source", "wikipedia", "Text source: 'wikipedia', 'wikipedia-extract', 'wiktionary', 'wikiquote' or 'file'")
+	flag.StringVar(&corpusPath, "corpus", "", "Path to a corpus file, required when '-source=file'")
+	flag.StringVar(&format, "format", "text", "Output format: 'text', 'json', 'html', 'html-min' or 'markdown'. 'html-min' only drops whitespace between tags and collapses other whitespace runs to a single space, it is not a full minifier")
+	flag.StringVar(&tag, "tag", "p", "HTML tag used to wrap each block, for '-format=html' and '-format=html-min'")
+	flag.BoolVar(&wrap, "wrap", false, "Wrap 'json' output in a single JSON array instead of a stream of objects")
+	flag.IntVar(&paragraphsPerBlock, "paragraphs-per-block", 1, "Number of extracts to group into one output block")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "Address to serve Prometheus metrics on, e.g. ':9090'. Disabled by default")
+	flag.Float64Var(&minRate, "min-rate", 1, "Minimum request rate limit in req/s the adaptive limiter will back off to")
+	flag.Float64Var(&maxRate, "max-rate", 0, "Maximum request rate limit in req/s the adaptive limiter will grow to. Defaults to '-rate'")
+	flag.Float64Var(&aimdStep, "aimd-step", 1, "Additive increase step, in req/s, applied after a window of successful requests")
+	flag.StringVar(&cacheDir, "cache-dir", "", "Directory to persist fetched extracts to, for reuse with '-offline'")
+	flag.DurationVar(&cacheTTL, "cache-ttl", 0, "Discard cached entries older than this when serving '-offline', e.g. '24h'. Zero means never")
+	flag.BoolVar(&offline, "offline", false, "Serve random entries from '-cache-dir' instead of making network calls")
+	flag.StringVar(&memcacheServers, "memcache-servers", "", "Comma-separated memcached addresses to use as the cache backend instead of '-cache-dir'. Requires building with '-tags memcache'")
+	flag.StringVar(&serveAddr, "serve", "", "Address to serve an HTTP '/lorem' endpoint on, e.g. ':8080', instead of printing to stdout once")
+	flag.IntVar(&prefetchBuffer, "prefetch-buffer", 16, "Number of extracts to prefetch ahead of incoming requests in '-serve' mode")
+	flag.Float64Var(&clientRate, "client-rate", 1, "Per-client request rate limit in req/s for '-serve' mode")
+	flag.IntVar(&clientBurst, "client-burst", 5, "Per-client burst size for '-serve' mode")
 
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0], os.Args[0])
@@ -63,12 +99,18 @@ func main() {
 		os.Exit(0)
 	}
 
-	if userAgent == "" {
-		fmt.Println("'-user-agent' is required")
-		os.Exit(1)
+	if source != "file" && !offline {
+		if userAgent == "" {
+			fmt.Println("'-user-agent' is required")
+			os.Exit(1)
+		}
+		if lang == "" {
+			fmt.Println("'-lang' is required")
+			os.Exit(1)
+		}
 	}
-	if lang == "" {
-		fmt.Println("'-lang' is required")
+	if offline && cacheDir == "" && memcacheServers == "" {
+		fmt.Println("'-offline' requires '-cache-dir' or '-memcache-servers'")
 		os.Exit(1)
 	}
 	length, err := str2bytes(lengthStr)
@@ -79,73 +121,99 @@ func main() {
 	if rateLimit <= 0 || rateLimit > maxRateLimit {
 		rateLimit = maxRateLimit
 	}
+	if paragraphsPerBlock < 1 {
+		paragraphsPerBlock = 1
+	}
+	if maxRate <= 0 {
+		maxRate = rateLimit
+	}
 
 	if verbose {
 		fmt.Fprintf(os.Stderr, "Rate limit: %f\n", rateLimit)
 	}
 
-	wiki := wikiClient{
-		url:       fmt.Sprintf(randomSummaryURL, lang),
-		userAgent: userAgent,
-		client:    http.Client{Timeout: 5 * time.Second},
+	var cache cacheBackend
+	if cacheDir != "" || memcacheServers != "" {
+		cache, err = newCacheBackend(cacheDir, cacheTTL, splitMemcacheServers(memcacheServers))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	var src TextSource
+	if offline {
+		src = &offlineSource{backend: cache}
+	} else {
+		src, err = newTextSource(source, lang, userAgent, corpusPath, http.Client{Timeout: 5 * time.Second})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if cache != nil {
+			src = &cachingSource{TextSource: src, backend: cache}
+		}
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
-	txtch := make(chan []byte) // Channel to send random text.
-	errch := make(chan error)  // Channel to send errors.
-
-	go func() {
-		sleepch := make(chan time.Duration) // Channel to pause creating go routines.
-		lim := rate.NewLimiter(rate.Limit(rateLimit), 1)
-		for {
-
-			// Pause when we need to back off due to errors.
-			select {
-			case d := <-sleepch:
-				time.Sleep(d)
-			default:
-			}
+	errch := make(chan error) // Channel to send errors.
 
-			// Wait for the next available event so we don't exceed the rate limit.
-			if err := lim.Wait(ctx); err != nil {
-				errch <- err
-				return
-			}
+	var m *metrics
+	if metricsAddr != "" {
+		reg := prometheus.NewRegistry()
+		m = newMetrics(reg)
+		go serveMetrics(metricsAddr, reg, errch)
+	}
+	m.setRateLimit(rateLimit)
 
-			go func() {
-				op := func() error {
-					if verbose {
-						fmt.Fprint(os.Stderr, ".")
-					}
-					b, err := wiki.RandomSummary(ctx)
-					if errors.Is(err, errTooManyRequests) {
-						errch <- err
-						return err // Back off when we have 429 Too Many Requests response.
-					}
-					if err != nil {
-						errch <- err
-						return nil
-					}
-					txtch <- b
-					return nil
-				}
-				notify := func(_ error, next time.Duration) {
-					sleepch <- next
-				}
+	aimd := newAIMDLimiter(rateLimit, minRate, maxRate, aimdStep)
 
-				if err := backoff.RetryNotify(op, backoff.NewExponentialBackOff(), notify); err != nil {
-					errch <- err
+	if serveAddr != "" {
+		buffer := prefetchBuffer
+		if buffer < 1 {
+			buffer = 1
+		}
+		pool := startProducer(ctx, src, aimd, m, verbose, buffer, errch)
+		go func() {
+			for err := range errch {
+				if verbose {
+					fmt.Fprintln(os.Stderr, err)
 				}
-			}()
+			}
+		}()
+		if err := runServer(ctx, serveAddr, pool, format, tag, wrap, clientRate, clientBurst); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
-	}()
+		cancel()
+		return
+	}
+
+	formatter, err := newFormatter(format, tag, wrap, os.Stdout)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := formatter.Begin(os.Stdout); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	txtch := startProducer(ctx, src, aimd, m, verbose, 0, errch)
 
 	sigch := make(chan os.Signal) // Channel to send OS signal to terminate this program.
 	l := 0
+	var block [][]byte // Extracts waiting to be grouped into one block.
 	for {
 		select {
 		case txt := <-txtch:
-			n, _ := fmt.Fprintln(os.Stdout, string(txt))
+			block = append(block, txt)
+			if len(block) < paragraphsPerBlock {
+				continue
+			}
+			n, _ := formatter.Write(bytes.Join(block, []byte(" ")))
+			block = block[:0]
+			m.addBytesProduced(n)
 			if length > 0 {
 				l += n
 				if l >= length {
@@ -174,6 +242,12 @@ func main() {
 	}
 
 End:
+	if len(block) > 0 {
+		formatter.Write(bytes.Join(block, []byte(" ")))
+	}
+	if err := formatter.End(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
 	cancel()
 }
 
@@ -223,10 +297,21 @@ type wikiClient struct {
 var errTooManyRequests = errors.New("too many requests")
 
 // RandomText returns text from a random Wikipedia page.
-func (w *wikiClient) RandomSummary(ctx context.Context) ([]byte, error) {
+func (w *wikiClient) RandomText(ctx context.Context) ([]byte, error) {
+	_, b, err := w.randomTextKeyed(ctx)
+	return b, err
+}
+
+// randomTextKeyed behaves like RandomText, additionally returning a key
+// built from the endpoint and the page's ID that identifies the page
+// stably across revisions. It implements textKeyer so cachingSource can
+// key the cache by page identity instead of content, letting a later
+// fetch of a revised page replace its old cache entry instead of
+// accumulating a duplicate.
+func (w *wikiClient) randomTextKeyed(ctx context.Context) (string, []byte, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.url, nil)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 	req.Header.Add("Accept", "application/problem+json")
 	req.Header.Add("User-Agent", w.userAgent)
@@ -234,25 +319,28 @@ func (w *wikiClient) RandomSummary(ctx context.Context) ([]byte, error) {
 	resp, err := w.client.Do(req)
 
 	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
-		return nil, err
+		return "", nil, err
 	}
 	var timeoutErr interface {
 		Timeout() bool
 	}
 	if errors.As(err, &timeoutErr) && timeoutErr.Timeout() {
-		return nil, err
+		return "", nil, err
 	}
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 
 	if resp.StatusCode == http.StatusTooManyRequests {
-		return nil, errTooManyRequests
+		return "", nil, &tooManyRequestsError{retryAfterDur: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	if resp.StatusCode >= 500 {
+		return "", nil, &serverError{status: resp.StatusCode, retryAfterDur: parseRetryAfter(resp.Header.Get("Retry-After"))}
 	}
 
-	fail := func(format string, a ...interface{}) ([]byte, error) {
+	fail := func(format string, a ...interface{}) (string, []byte, error) {
 		a = append(a, req, resp)
-		return nil, fmt.Errorf(format+"\n\nrequest:\n%v\n\nresponse:\n%v\n", a...)
+		return "", nil, fmt.Errorf(format+"\n\nrequest:\n%v\n\nresponse:\n%v\n", a...)
 	}
 
 	if resp.StatusCode != http.StatusOK {
@@ -260,15 +348,19 @@ func (w *wikiClient) RandomSummary(ctx context.Context) ([]byte, error) {
 	}
 
 	if ctype, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err != nil {
-		return nil, err
+		return "", nil, err
 	} else if ctype != "application/json" {
 		return fail("response content type %q", ctype)
 	}
 
-	var body struct{ Extract string `json:"extract"` }
+	var body struct {
+		PageID  int    `json:"pageid"`
+		Extract string `json:"extract"`
+	}
 	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
 		return fail("%w", err)
 	}
 
-	return []byte(strings.TrimSpace(body.Extract)), nil
+	key := fmt.Sprintf("%s:%d", w.url, body.PageID)
+	return key, []byte(strings.TrimSpace(body.Extract)), nil
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the Prometheus instrumentation for a wikiipsum run. A nil
+// *metrics is safe to call every method on, so call sites don't need to
+// guard every metric update behind "if -metrics-addr is set".
+type metrics struct {
+	requestsTotal  *prometheus.CounterVec
+	requestLatency prometheus.Histogram
+	rateLimit      prometheus.Gauge
+	bytesProduced  prometheus.Counter
+	inFlight       prometheus.Gauge
+}
+
+// newMetrics registers the wikiipsum collectors on reg.
+func newMetrics(reg prometheus.Registerer) *metrics {
+	f := promauto.With(reg)
+	return &metrics{
+		requestsTotal: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "wikiipsum_requests_total",
+			Help: "Total number of source requests, broken down by HTTP status (\"429\", a 5xx status code, \"error\" or \"ok\").",
+		}, []string{"status"}),
+		requestLatency: f.NewHistogram(prometheus.HistogramOpts{
+			Name:    "wikiipsum_request_duration_seconds",
+			Help:    "Latency of source requests.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		rateLimit: f.NewGauge(prometheus.GaugeOpts{
+			Name: "wikiipsum_rate_limit",
+			Help: "Current effective request rate limit, in requests per second.",
+		}),
+		bytesProduced: f.NewCounter(prometheus.CounterOpts{
+			Name: "wikiipsum_bytes_produced_total",
+			Help: "Total number of output bytes produced.",
+		}),
+		inFlight: f.NewGauge(prometheus.GaugeOpts{
+			Name: "wikiipsum_in_flight_requests",
+			Help: "Number of source requests currently in flight.",
+		}),
+	}
+}
+
+// observeRequest records the outcome and latency of a single RandomText
+// call started at start.
+func (m *metrics) observeRequest(start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	status := "ok"
+	var srvErr *serverError
+	switch {
+	case errors.Is(err, errTooManyRequests):
+		status = "429"
+	case errors.As(err, &srvErr):
+		status = strconv.Itoa(srvErr.status)
+	case err != nil:
+		status = "error"
+	}
+	m.requestsTotal.WithLabelValues(status).Inc()
+	m.requestLatency.Observe(time.Since(start).Seconds())
+}
+
+func (m *metrics) addInFlight(delta float64) {
+	if m == nil {
+		return
+	}
+	m.inFlight.Add(delta)
+}
+
+func (m *metrics) setRateLimit(limit float64) {
+	if m == nil {
+		return
+	}
+	m.rateLimit.Set(limit)
+}
+
+func (m *metrics) addBytesProduced(n int) {
+	if m == nil {
+		return
+	}
+	m.bytesProduced.Add(float64(n))
+}
+
+// serveMetrics starts an HTTP server on addr exposing Prometheus metrics at
+// /metrics. It blocks until the server stops; any error is sent on errch so
+// the caller can decide whether to treat it as fatal.
+func serveMetrics(addr string, reg *prometheus.Registry, errch chan<- error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		errch <- fmt.Errorf("metrics server: %w", err)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// startProducer launches the background worker pool that fetches chunks
+// from src, respecting aimd's adaptive rate limit, and returns the channel
+// chunks are delivered on. buffer sets the channel's capacity: zero keeps
+// the CLI's original unbuffered, one-chunk-at-a-time behavior; a positive
+// buffer lets -serve mode prefetch ahead of incoming HTTP requests.
+//
+// The number of concurrent fetches in flight (including ones blocked
+// handing their result to txtch) is capped at buffer, or 1 if buffer is
+// zero. This is what applies back-pressure: once the prefetch buffer is
+// full and nothing is reading from txtch, every worker is parked on the
+// send and no further requests reach src until a reader drains the
+// channel, instead of fetching at the full configured rate regardless of
+// demand.
+//
+// Errors are sent on errch. A backoff signal (429/5xx) halves the limiter
+// and honors any Retry-After hint before the error is reported.
+func startProducer(ctx context.Context, src TextSource, aimd *aimdLimiter, m *metrics, verbose bool, buffer int, errch chan<- error) <-chan []byte {
+	txtch := make(chan []byte, buffer)
+
+	inFlight := buffer
+	if inFlight < 1 {
+		inFlight = 1
+	}
+	sem := make(chan struct{}, inFlight)
+
+	go func() {
+		for {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			if err := aimd.Wait(ctx); err != nil {
+				<-sem
+				errch <- err
+				return
+			}
+			m.setRateLimit(aimd.Limit())
+
+			go func() {
+				defer func() { <-sem }()
+
+				if verbose {
+					fmt.Fprint(os.Stderr, ".")
+				}
+				m.addInFlight(1)
+				start := time.Now()
+				b, err := src.RandomText(ctx)
+				m.addInFlight(-1)
+				m.observeRequest(start, err)
+
+				var signal backoffSignal
+				if errors.As(err, &signal) {
+					aimd.onFailure()
+					if d := signal.retryAfter(); d > 0 {
+						time.Sleep(d)
+					}
+					errch <- err
+					return
+				}
+				if err != nil {
+					errch <- err
+					return
+				}
+
+				aimd.onSuccess()
+				select {
+				case txtch <- b:
+				case <-ctx.Done():
+				}
+			}()
+		}
+	}()
+
+	return txtch
+}
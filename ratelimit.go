@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// backoffSignal is implemented by errors that should trigger an AIMD
+// backoff in the producer loop, i.e. 429 and 5xx responses. retryAfter
+// returns the server's Retry-After hint, or zero if it gave none.
+type backoffSignal interface {
+	retryAfter() time.Duration
+}
+
+// tooManyRequestsError reports a 429 Too Many Requests response.
+type tooManyRequestsError struct {
+	retryAfterDur time.Duration
+}
+
+func (e *tooManyRequestsError) Error() string {
+	return errTooManyRequests.Error()
+}
+func (e *tooManyRequestsError) Unwrap() error { return errTooManyRequests }
+func (e *tooManyRequestsError) retryAfter() time.Duration {
+	return e.retryAfterDur
+}
+
+// serverError reports a 5xx response.
+type serverError struct {
+	status        int
+	retryAfterDur time.Duration
+}
+
+func (e *serverError) Error() string {
+	return "response status " + http.StatusText(e.status)
+}
+func (e *serverError) retryAfter() time.Duration { return e.retryAfterDur }
+
+// parseRetryAfter parses the Retry-After header value, in either the
+// delta-seconds or the HTTP-date form. It returns zero if h is empty or
+// unparseable, or if the parsed date is already in the past.
+func parseRetryAfter(h string) time.Duration {
+	if h == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(h); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// aimdLimiter is a rate.Limiter whose limit is adjusted additively on
+// success and multiplicatively on failure (AIMD), shared across all
+// producer workers instead of backing off per-goroutine. It is safe for
+// concurrent use.
+type aimdLimiter struct {
+	lim  *rate.Limiter
+	step float64
+	min  float64
+	max  float64
+
+	successWindow int // number of consecutive successes between additive increases
+
+	mu     sync.Mutex
+	streak int
+}
+
+// newAIMDLimiter returns an aimdLimiter starting at initial req/s (capped
+// at max), increasing by step req/s (up to max) every successWindow
+// consecutive successes and halving (down to min) on every backoff signal.
+// An initial rate below min is kept as given.
+func newAIMDLimiter(initial, min, max, step float64) *aimdLimiter {
+	if max <= 0 {
+		max = maxRateLimit
+	}
+	if min <= 0 {
+		min = 1
+	}
+	if initial <= 0 || initial > max {
+		initial = max
+	}
+	if step <= 0 {
+		step = 1
+	}
+	return &aimdLimiter{
+		lim:           rate.NewLimiter(rate.Limit(initial), 1),
+		step:          step,
+		min:           min,
+		max:           max,
+		successWindow: 10,
+	}
+}
+
+// Wait blocks until a request is permitted by the current limit, or ctx is
+// done.
+func (a *aimdLimiter) Wait(ctx context.Context) error {
+	return a.lim.Wait(ctx)
+}
+
+// Limit returns the current effective rate limit, in requests per second.
+func (a *aimdLimiter) Limit() float64 {
+	return float64(a.lim.Limit())
+}
+
+// onSuccess additively increases the limit by step every successWindow
+// consecutive successes, up to max.
+func (a *aimdLimiter) onSuccess() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.streak++
+	if a.streak < a.successWindow {
+		return
+	}
+	a.streak = 0
+	next := float64(a.lim.Limit()) + a.step
+	if next > a.max {
+		next = a.max
+	}
+	a.lim.SetLimit(rate.Limit(next))
+}
+
+// onFailure halves the limit, down to min, and resets the success streak.
+func (a *aimdLimiter) onFailure() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.streak = 0
+	next := float64(a.lim.Limit()) / 2
+	if next < a.min {
+		next = a.min
+	}
+	a.lim.SetLimit(rate.Limit(next))
+}
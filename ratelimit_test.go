@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name string
+		h    string
+		want time.Duration
+	}{
+		{name: "empty header", h: "", want: 0},
+		{name: "unparseable header", h: "not-a-duration", want: 0},
+		{name: "delta-seconds", h: "120", want: 120 * time.Second},
+		{name: "negative delta-seconds", h: "-5", want: 0},
+		{
+			name: "HTTP-date in the future",
+			h:    time.Now().Add(time.Hour).UTC().Format(http.TimeFormat),
+			want: time.Hour,
+		},
+		{
+			name: "HTTP-date in the past",
+			h:    time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat),
+			want: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseRetryAfter(tt.h)
+			// The HTTP-date cases round-trip through a duration computed at
+			// table-build time vs. parse time, so allow a small skew instead
+			// of requiring exact equality.
+			diff := got - tt.want
+			if diff < -time.Second || diff > time.Second {
+				t.Errorf("parseRetryAfter(%q) = %v, want ~%v", tt.h, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAIMDLimiter_OnSuccess(t *testing.T) {
+	a := newAIMDLimiter(10, 1, 12, 2)
+
+	for i := 0; i < a.successWindow-1; i++ {
+		a.onSuccess()
+	}
+	if got := a.Limit(); got != 10 {
+		t.Fatalf("limit after %d successes = %v, want unchanged 10", a.successWindow-1, got)
+	}
+
+	a.onSuccess()
+	if got := a.Limit(); got != 12 {
+		t.Fatalf("limit after %d successes = %v, want 12", a.successWindow, got)
+	}
+
+	for i := 0; i < a.successWindow; i++ {
+		a.onSuccess()
+	}
+	if got := a.Limit(); got != 12 {
+		t.Fatalf("limit after exceeding max = %v, want capped at 12", got)
+	}
+}
+
+func TestAIMDLimiter_OnFailure(t *testing.T) {
+	a := newAIMDLimiter(10, 3, 20, 2)
+
+	a.onFailure()
+	if got := a.Limit(); got != 5 {
+		t.Fatalf("limit after one failure = %v, want 5", got)
+	}
+
+	a.onFailure()
+	if got := a.Limit(); got != 3 {
+		t.Fatalf("limit after two failures = %v, want floored at 3", got)
+	}
+
+	a.onFailure()
+	if got := a.Limit(); got != 3 {
+		t.Fatalf("limit after failure at floor = %v, want still 3", got)
+	}
+}
+
+func TestAIMDLimiter_OnFailureResetsStreak(t *testing.T) {
+	a := newAIMDLimiter(10, 1, 20, 2)
+
+	for i := 0; i < a.successWindow-1; i++ {
+		a.onSuccess()
+	}
+	a.onFailure()
+	a.onSuccess()
+
+	// onFailure halved the limit to 5; had the streak survived, this last
+	// success would complete a window and raise it to 7.
+	if got := a.Limit(); got != 5 {
+		t.Fatalf("limit = %v, want 5: onFailure should have reset the success streak", got)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// contentTypes maps a -format value to the Content-Type served by /lorem.
+var contentTypes = map[string]string{
+	"text":     "text/plain; charset=utf-8",
+	"json":     "application/json; charset=utf-8",
+	"html":     "text/html; charset=utf-8",
+	"html-min": "text/html; charset=utf-8",
+	"markdown": "text/markdown; charset=utf-8",
+}
+
+// runServer turns wikiipsum into a long-running HTTP service exposing
+// /lorem, /healthz and /version, reading prefetched chunks from pool. It
+// blocks until ctx is done or the server fails to start.
+func runServer(ctx context.Context, addr string, pool <-chan []byte, defaultFormat, tag string, wrap bool, clientRate float64, clientBurst int) error {
+	limiter := newClientLimiter(ctx, clientRate, clientBurst)
+
+	mux := http.NewServeMux()
+	// /healthz and /version are liveness/readiness probes, not client
+	// traffic against the text source, so they're exempt from the
+	// per-client limiter: mount them directly instead of behind it.
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, Version)
+	})
+	mux.Handle("/lorem", limiter.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		loremHandler(w, r, pool, defaultFormat, tag, wrap)
+	})))
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// loremHandler serves GET /lorem?length=500&format=json, generating
+// output from pool until length bytes have been written (500 by default).
+func loremHandler(w http.ResponseWriter, r *http.Request, pool <-chan []byte, defaultFormat, tag string, wrap bool) {
+	length, err := str2bytes(r.URL.Query().Get("length"))
+	if err != nil {
+		length = 500
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = defaultFormat
+	}
+	contentType, ok := contentTypes[format]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+		return
+	}
+
+	formatter, err := newFormatter(format, tag, wrap, w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if err := formatter.Begin(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	l := 0
+	for l < length {
+		select {
+		case b := <-pool:
+			n, _ := formatter.Write(b)
+			l += n
+		case <-r.Context().Done():
+			return
+		}
+	}
+	formatter.End()
+}
+
+// clientIdleTTL is how long a client's bucket is kept after its last
+// request before clientLimiter evicts it. Without eviction, a client
+// rotating source addresses would grow limiters without bound -- the
+// exact kind of resource exhaustion this limiter exists to prevent.
+const clientIdleTTL = 10 * time.Minute
+
+// clientBucket is a single client's token bucket, plus when it was last
+// used so clientLimiter can evict idle entries.
+type clientBucket struct {
+	lim      *rate.Limiter
+	lastSeen time.Time
+}
+
+// clientLimiter is a per-client-IP token-bucket rate limiter middleware,
+// independent of the upstream aimdLimiter that protects the text source.
+// Idle buckets are evicted periodically so the bucket map doesn't grow
+// without bound.
+type clientLimiter struct {
+	rate  rate.Limit
+	burst int
+
+	mu      sync.Mutex
+	buckets map[string]*clientBucket
+}
+
+func newClientLimiter(ctx context.Context, reqPerSec float64, burst int) *clientLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	c := &clientLimiter{
+		rate:    rate.Limit(reqPerSec),
+		burst:   burst,
+		buckets: make(map[string]*clientBucket),
+	}
+	go c.evictLoop(ctx)
+	return c
+}
+
+// evictLoop periodically removes buckets that have been idle for longer
+// than clientIdleTTL, until ctx is done.
+func (c *clientLimiter) evictLoop(ctx context.Context) {
+	ticker := time.NewTicker(clientIdleTTL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.evict()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *clientLimiter) evict() {
+	cutoff := time.Now().Add(-clientIdleTTL)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, b := range c.buckets {
+		if b.lastSeen.Before(cutoff) {
+			delete(c.buckets, key)
+		}
+	}
+}
+
+func (c *clientLimiter) allow(remoteAddr string) bool {
+	key := clientKey(remoteAddr)
+
+	c.mu.Lock()
+	b, ok := c.buckets[key]
+	if !ok {
+		b = &clientBucket{lim: rate.NewLimiter(c.rate, c.burst)}
+		c.buckets[key] = b
+	}
+	b.lastSeen = time.Now()
+	c.mu.Unlock()
+
+	return b.lim.Allow()
+}
+
+func (c *clientLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.allow(r.RemoteAddr) {
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientKey maps a RemoteAddr to a rate-limiting bucket key: an IPv4
+// address as-is, or an IPv6 address masked to its /64, so a client can't
+// evade the limit by rotating addresses within their own subnet.
+func clientKey(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String()
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestClientKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		want       string
+	}{
+		{
+			name:       "IPv4 with port",
+			remoteAddr: "203.0.113.7:54321",
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "IPv6 addresses in the same /64 share a key",
+			remoteAddr: "[2001:db8:1234:5678::1]:443",
+			want:       "2001:db8:1234:5678::",
+		},
+		{
+			name:       "a different host within the same /64",
+			remoteAddr: "[2001:db8:1234:5678:ffff:ffff:ffff:ffff]:443",
+			want:       "2001:db8:1234:5678::",
+		},
+		{
+			name:       "a different /64 gets a different key",
+			remoteAddr: "[2001:db8:1234:5679::1]:443",
+			want:       "2001:db8:1234:5679::",
+		},
+		{
+			name:       "no port falls back to the raw host",
+			remoteAddr: "203.0.113.7",
+			want:       "203.0.113.7",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clientKey(tt.remoteAddr); got != tt.want {
+				t.Errorf("clientKey(%q) = %q, want %q", tt.remoteAddr, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// TextSource produces a chunk of random text for use as ipsum content.
+// Implementations may hit a remote API, read from a local file, or serve
+// from a cache; the producer loop in main only depends on this interface,
+// so new backends can be added without touching the rate-limit/backoff
+// plumbing.
+type TextSource interface {
+	// RandomText returns text from a random entry of the source.
+	RandomText(ctx context.Context) ([]byte, error)
+}
+
+// newTextSource builds the TextSource selected by -source. lang and
+// userAgent are only used by the Wikimedia-backed sources; corpusPath is
+// only used by "file".
+func newTextSource(source, lang, userAgent, corpusPath string, client http.Client) (TextSource, error) {
+	switch source {
+	case "", "wikipedia":
+		return &wikiClient{
+			url:       fmt.Sprintf(randomSummaryURL, lang),
+			userAgent: userAgent,
+			client:    client,
+		}, nil
+	case "wikipedia-extract":
+		return newMediaWikiExtractClient(lang, "wikipedia", userAgent, client), nil
+	case "wiktionary":
+		return newMediaWikiExtractClient(lang, "wiktionary", userAgent, client), nil
+	case "wikiquote":
+		return newMediaWikiExtractClient(lang, "wikiquote", userAgent, client), nil
+	case "file":
+		if corpusPath == "" {
+			return nil, errors.New("'-corpus' is required when '-source=file'")
+		}
+		return newFileCorpusSource(corpusPath)
+	default:
+		return nil, fmt.Errorf("unknown source %q", source)
+	}
+}
+
+// randomTitleURLPattern is the fmt.Sprintf pattern of the REST API URL
+// for a random page title on a Wikimedia project, e.g.
+//
+//	fmt.Sprintf(randomTitleURLPattern, "en.wiktionary.org")
+const randomTitleURLPattern = "https://%s/api/rest_v1/page/random/title"
+
+// extractsURLPattern is the fmt.Sprintf pattern of the MediaWiki action API
+// URL for the plain text extract of a page, e.g.
+//
+//	fmt.Sprintf(extractsURLPattern, "en.wiktionary.org", "Go")
+const extractsURLPattern = "https://%s/w/api.php?action=query&format=json&prop=extracts&explaintext=1&redirects=1&titles=%s"
+
+// wikiExtractClient fetches the full plain text extract of a random page on
+// a Wikimedia project (Wikipedia, Wiktionary, Wikiquote, ...), unlike
+// wikiClient which only fetches the short REST summary.
+type wikiExtractClient struct {
+	host      string // e.g. "en.wikipedia.org"
+	userAgent string
+	client    http.Client
+}
+
+// newMediaWikiExtractClient returns a wikiExtractClient for lang.project.org,
+// e.g. newMediaWikiExtractClient("en", "wiktionary", ...) targets
+// en.wiktionary.org.
+func newMediaWikiExtractClient(lang, project, userAgent string, client http.Client) *wikiExtractClient {
+	return &wikiExtractClient{
+		host:      fmt.Sprintf("%s.%s.org", lang, project),
+		userAgent: userAgent,
+		client:    client,
+	}
+}
+
+// RandomText returns the plain text extract of a random page.
+func (w *wikiExtractClient) RandomText(ctx context.Context) ([]byte, error) {
+	_, b, err := w.randomTextKeyed(ctx)
+	return b, err
+}
+
+// randomTextKeyed behaves like RandomText, additionally returning a key
+// that identifies the page (host plus its MediaWiki page ID) stably across
+// revisions. It implements textKeyer so cachingSource can key the cache by
+// page identity instead of content, letting a later fetch of a revised
+// page replace its old cache entry instead of accumulating a duplicate.
+func (w *wikiExtractClient) randomTextKeyed(ctx context.Context) (string, []byte, error) {
+	title, err := w.randomTitle(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	pageID, b, err := w.extract(ctx, title)
+	if err != nil {
+		return "", nil, err
+	}
+	return w.host + ":" + pageID, b, nil
+}
+
+func (w *wikiExtractClient) randomTitle(ctx context.Context) (string, error) {
+	reqURL := fmt.Sprintf(randomTitleURLPattern, w.host)
+	var body struct {
+		Items []struct {
+			Title string `json:"title"`
+		} `json:"items"`
+	}
+	if err := w.getJSON(ctx, reqURL, &body); err != nil {
+		return "", err
+	}
+	if len(body.Items) == 0 {
+		return "", errors.New("no random title returned")
+	}
+	return body.Items[0].Title, nil
+}
+
+// extractURL builds the MediaWiki action API URL for the extract of title
+// on host, escaping title so page names containing '&', spaces or
+// non-ASCII characters (e.g. "Fish & chips", "Café") don't corrupt the
+// query string.
+func extractURL(host, title string) string {
+	return fmt.Sprintf(extractsURLPattern, host, url.QueryEscape(title))
+}
+
+// extract returns the plain text extract of title, along with the
+// MediaWiki page ID the action API returned it under (the key of
+// body.Query.Pages).
+func (w *wikiExtractClient) extract(ctx context.Context, title string) (string, []byte, error) {
+	reqURL := extractURL(w.host, title)
+	var body struct {
+		Query struct {
+			Pages map[string]struct {
+				Extract string `json:"extract"`
+			} `json:"pages"`
+		} `json:"query"`
+	}
+	if err := w.getJSON(ctx, reqURL, &body); err != nil {
+		return "", nil, err
+	}
+	for pageID, page := range body.Query.Pages {
+		if page.Extract != "" {
+			return pageID, []byte(strings.TrimSpace(page.Extract)), nil
+		}
+	}
+	return "", nil, errors.New("no extract returned")
+}
+
+func (w *wikiExtractClient) getJSON(ctx context.Context, reqURL string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("User-Agent", w.userAgent)
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &tooManyRequestsError{retryAfterDur: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	if resp.StatusCode >= 500 {
+		return &serverError{status: resp.StatusCode, retryAfterDur: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("response status %s\n\nrequest:\n%v\n", resp.Status, req)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// fileCorpusSource serves random entries from a local corpus file instead of
+// making network calls, useful for tests and offline fixture generation.
+// The file may be newline-delimited plain text or a JSON array of strings.
+type fileCorpusSource struct {
+	entries [][]byte
+}
+
+func newFileCorpusSource(path string) (*fileCorpusSource, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries [][]byte
+	var arr []string
+	if err := json.Unmarshal(b, &arr); err == nil {
+		for _, s := range arr {
+			if s = strings.TrimSpace(s); s != "" {
+				entries = append(entries, []byte(s))
+			}
+		}
+	} else {
+		for _, line := range strings.Split(string(b), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				entries = append(entries, []byte(line))
+			}
+		}
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("corpus file %q contains no entries", path)
+	}
+	return &fileCorpusSource{entries: entries}, nil
+}
+
+// RandomText returns a random entry from the corpus.
+func (f *fileCorpusSource) RandomText(context.Context) ([]byte, error) {
+	return f.entries[rand.Intn(len(f.entries))], nil
+}
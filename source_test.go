@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtractURL(t *testing.T) {
+	tests := []struct {
+		name  string
+		host  string
+		title string
+		want  string
+	}{
+		{
+			name:  "simple title",
+			host:  "en.wikipedia.org",
+			title: "Go",
+			want:  "https://en.wikipedia.org/w/api.php?action=query&format=json&prop=extracts&explaintext=1&redirects=1&titles=Go",
+		},
+		{
+			name:  "ampersand is escaped so it doesn't start a new query param",
+			host:  "en.wikipedia.org",
+			title: "Fish & chips",
+			want:  "https://en.wikipedia.org/w/api.php?action=query&format=json&prop=extracts&explaintext=1&redirects=1&titles=Fish+%26+chips",
+		},
+		{
+			name:  "spaces and parentheses are escaped",
+			host:  "en.wikipedia.org",
+			title: "Go (programming language)",
+			want:  "https://en.wikipedia.org/w/api.php?action=query&format=json&prop=extracts&explaintext=1&redirects=1&titles=Go+%28programming+language%29",
+		},
+		{
+			name:  "unicode is escaped",
+			host:  "fr.wikipedia.org",
+			title: "Café",
+			want:  "https://fr.wikipedia.org/w/api.php?action=query&format=json&prop=extracts&explaintext=1&redirects=1&titles=Caf%C3%A9",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractURL(tt.host, tt.title); got != tt.want {
+				t.Errorf("extractURL(%q, %q) = %q, want %q", tt.host, tt.title, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewFileCorpusSource_JSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corpus.json")
+	if err := os.WriteFile(path, []byte(`["foo", "bar", ""]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := newFileCorpusSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(src.entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(src.entries))
+	}
+}
+
+func TestNewFileCorpusSource_NewlineDelimited(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corpus.txt")
+	content := "foo\n\nbar  \n  \nbaz"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := newFileCorpusSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(src.entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(src.entries))
+	}
+}
+
+func TestNewFileCorpusSource_Empty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corpus.txt")
+	if err := os.WriteFile(path, []byte("\n\n  \n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newFileCorpusSource(path); err == nil {
+		t.Fatal("expected an error for an empty corpus")
+	} else if !strings.Contains(err.Error(), "no entries") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}